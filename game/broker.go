@@ -0,0 +1,88 @@
+package game
+
+import (
+	"sync"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// StreamEventType identifies what kind of update a StreamEvent carries.
+type StreamEventType string
+
+const (
+	StreamEventMessage    StreamEventType = "message"
+	StreamEventChannel    StreamEventType = "channel"
+	StreamEventReadMarker StreamEventType = "read-marker"
+)
+
+// StreamEvent is what gameBroker fans out to subscribers of
+// `/Game/{game_id}/Messages/Stream` and `/Game/{game_id}/Channels/Stream`.
+// Exactly one of Message, Channel or ReadMarker is set, matching Type.
+type StreamEvent struct {
+	Type       StreamEventType
+	GameID     *datastore.Key
+	Message    *Message    `json:",omitempty"`
+	Channel    *Channel    `json:",omitempty"`
+	ReadMarker *ReadMarker `json:",omitempty"`
+}
+
+// broker fans out StreamEvents to subscribers of a single game, keyed by
+// gameID, the same shape soju uses for its per-network event bus. It is a
+// single in-process, package-level map: it has no persistence and no
+// cross-instance fan-out, so on multi-instance appengine a subscriber
+// connected to instance A never sees an event published by a request
+// instance B handled. A subscriber that isn't connected at all when an event
+// is published simply misses it too. The message stream papers over both
+// cases with `Last-Event-ID` backfill via listMessages-style history;
+// streamChannels has no such backfill, so `channel` and `read-marker` events
+// are delivery-best-effort only — see the doc comment on streamChannels.
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan StreamEvent]bool
+}
+
+var gameBroker = &broker{subscribers: map[string]map[chan StreamEvent]bool{}}
+
+func (b *broker) subscribe(gameID *datastore.Key) chan StreamEvent {
+	ch := make(chan StreamEvent, 16)
+
+	key := gameID.Encode()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[key] == nil {
+		b.subscribers[key] = map[chan StreamEvent]bool{}
+	}
+	b.subscribers[key][ch] = true
+	return ch
+}
+
+func (b *broker) unsubscribe(gameID *datastore.Key, ch chan StreamEvent) {
+	key := gameID.Encode()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers[key], ch)
+	if len(b.subscribers[key]) == 0 {
+		delete(b.subscribers, key)
+	}
+	close(ch)
+}
+
+// publish fans event out to every current subscriber of gameID. A
+// subscriber whose buffer is full is skipped rather than blocking the
+// publisher. A skipped `message` event can be recovered by reconnecting to
+// the message stream with a Last-Event-ID older than it; a skipped `channel`
+// or `read-marker` event is lost, as streamChannels has no backfill.
+func (b *broker) publish(gameID *datastore.Key, event StreamEvent) {
+	event.GameID = gameID
+
+	key := gameID.Encode()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[key] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/zond/diplicity/auth"
 	"github.com/zond/godip/variants"
@@ -18,8 +21,29 @@ import (
 )
 
 const (
-	messageKind = "Message"
-	channelKind = "Channel"
+	messageKind    = "Message"
+	channelKind    = "Channel"
+	readMarkerKind = "ReadMarker"
+
+	// SearchMessagesRoute names the GET /Game/{game_id}/Messages/Search
+	// route searchMessages implements, the same way ListMessagesRoute and
+	// ListChannelsRoute name the listMessages/listChannels routes. Binding
+	// this name (and searchMessages itself) to that path/method happens in
+	// the package's central resource registration, which is outside this
+	// snapshot — same as ListMessagesRoute/ListChannelsRoute, which this
+	// file already depends on without defining.
+	SearchMessagesRoute = "Search"
+
+	// maxMessagesLimit is the server side cap on `limit`, regardless of what
+	// the client asked for.
+	maxMessagesLimit = 100
+
+	// maxMessageTokens caps how many distinct search tokens a single message
+	// indexes. Every token explodes into its own composite index entry
+	// alongside the Sender/CreatedAt indexes search and history paging also
+	// need, so an unbounded Body could blow the per-entity exploding-index
+	// limit; 500 keeps even a very long press message well inside it.
+	maxMessageTokens = 500
 )
 
 type Nations []dip.Nation
@@ -129,7 +153,7 @@ func (c *Channel) CountSince(ctx context.Context, since time.Time) error {
 	if err != nil {
 		return err
 	}
-	count, err := datastore.NewQuery(messageKind).Ancestor(channelID).Filter("CreatedAt>", since).Count(ctx)
+	count, err := messageStore.CountSince(ctx, channelID, since)
 	if err != nil {
 		return err
 	}
@@ -138,6 +162,158 @@ func (c *Channel) CountSince(ctx context.Context, since time.Time) error {
 	return nil
 }
 
+// ReadMarker tracks how far a user has read into a channel, replacing the
+// client-supplied `since` cursor with a durable per-member marker — borrowed
+// from oragono's account read-marker store — so that every client and device
+// shares the same unread position instead of tracking its own.
+type ReadMarker struct {
+	ChannelID *datastore.Key
+	UserID    string
+	LastRead  time.Time
+}
+
+func (rm *ReadMarker) Item(r Request) *Item {
+	return NewItem(rm).SetName("read-marker")
+}
+
+func ReadMarkerID(ctx context.Context, channelID *datastore.Key, userID string) (*datastore.Key, error) {
+	if channelID == nil || userID == "" {
+		return nil, fmt.Errorf("read markers must have channels and users")
+	}
+	return datastore.NewKey(ctx, readMarkerKind, fmt.Sprintf("%s:%s", channelID.Encode(), userID), 0, nil), nil
+}
+
+// setReadMarker clamps `lastRead` to `now` and stores
+// `max(existing.LastRead, lastRead)`, so the marker never moves backwards.
+// `gameID` is only used to fan the resulting marker out as a `read-marker`
+// stream event; it plays no part in the marker's identity or storage.
+func setReadMarker(ctx context.Context, gameID, channelID *datastore.Key, userID string, lastRead time.Time) (*ReadMarker, error) {
+	markerID, err := ReadMarkerID(ctx, channelID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if lastRead.After(now) {
+		lastRead = now
+	}
+
+	marker := &ReadMarker{}
+	if err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		marker = &ReadMarker{ChannelID: channelID, UserID: userID}
+		if err := datastore.Get(ctx, markerID, marker); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if lastRead.After(marker.LastRead) {
+			marker.LastRead = lastRead
+		}
+		marker.ChannelID = channelID
+		marker.UserID = userID
+		_, err := datastore.Put(ctx, markerID, marker)
+		return err
+	}, &datastore.TransactionOptions{XG: false}); err != nil {
+		return nil, err
+	}
+
+	gameBroker.publish(gameID, StreamEvent{Type: StreamEventReadMarker, ReadMarker: marker})
+
+	return marker, nil
+}
+
+// DeleteGameReadMarkers removes every read marker for every channel of
+// `gameID`. Channels and read markers are not ancestor-keyed under the game,
+// so the lookups below are non-ancestor queries and cannot run inside an
+// appengine datastore transaction; callers must invoke this as a best-effort
+// cleanup step after the transaction that deletes the game and its channels
+// has committed, not from inside it.
+//
+// UNWIRED: no game-deletion path exists anywhere in this package (there is
+// no DeleteGame or equivalent here), so this function currently has no
+// caller and read markers are NOT cleaned up when a game goes away. Do not
+// read its existence as evidence that cleanup happens; whatever adds game
+// deletion to this package must call this function as part of doing so. It
+// must not be called when a single Member is deleted while the game is
+// still active — the marker is expected to survive that so a rejoining or
+// replaced player keeps their read position.
+func DeleteGameReadMarkers(ctx context.Context, gameID *datastore.Key) error {
+	channelKeys, err := datastore.NewQuery(channelKind).Filter("GameID=", gameID).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	markerKeys := []*datastore.Key{}
+	for _, channelID := range channelKeys {
+		keys, err := datastore.NewQuery(readMarkerKind).Filter("ChannelID=", channelID).KeysOnly().GetAll(ctx, nil)
+		if err != nil {
+			return err
+		}
+		markerKeys = append(markerKeys, keys...)
+	}
+	if len(markerKeys) == 0 {
+		return nil
+	}
+	return datastore.DeleteMulti(ctx, markerKeys)
+}
+
+type readMarkerUpdate struct {
+	LastRead time.Time `methods:"PUT"`
+}
+
+func updateReadMarker(w ResponseWriter, r Request) (*ReadMarker, error) {
+	ctx := appengine.NewContext(r.Req())
+
+	user, ok := r.Values()["user"].(*auth.User)
+	if !ok {
+		http.Error(w, "unauthorized", 401)
+		return nil, nil
+	}
+
+	gameID, err := datastore.DecodeKey(r.Vars()["game_id"])
+	if err != nil {
+		return nil, err
+	}
+
+	channelMembers := Nations{}
+	channelMembers.FromString(r.Vars()["channel_members"])
+
+	memberID, err := MemberID(ctx, gameID, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	member := &Member{}
+	if err := datastore.Get(ctx, memberID, member); err != nil {
+		return nil, err
+	}
+
+	if !channelMembers.Includes(member.Nation) {
+		http.Error(w, "can only mark member channels as read", 403)
+		return nil, nil
+	}
+
+	update := &readMarkerUpdate{}
+	if err := Copy(update, r, "PUT"); err != nil {
+		return nil, err
+	}
+
+	channelID, err := ChannelID(ctx, gameID, channelMembers)
+	if err != nil {
+		return nil, err
+	}
+
+	return setReadMarker(ctx, gameID, channelID, user.Id, update.LastRead)
+}
+
+// ReadMarkerResource is wired into the HTTP surface the same way
+// MessageResource below is: the central resource registration that binds a
+// *Resource to a router isn't part of this snapshot, so neither var is
+// reachable here on its own — both need that registration to actually serve
+// requests.
+var ReadMarkerResource = &Resource{
+	Update:     updateReadMarker,
+	UpdatePath: "/Game/{game_id}/Channel/{channel_members}/ReadMarker",
+}
+
 var MessageResource = &Resource{
 	Create:     createMessage,
 	CreatePath: "/Game/{game_id}/Messages",
@@ -145,7 +321,12 @@ var MessageResource = &Resource{
 
 type Messages []Message
 
-func (m Messages) Item(r Request, gameID *datastore.Key, channelMembers Nations) *Item {
+// Item renders the messages as a list item, adding `next`/`prev` links
+// computed from the first and last `CreatedAt` of the page so that clients
+// can walk the channel's history with the CHATHISTORY-style selectors
+// understood by `listMessages` (`before`, `after`, `around`, `latest`,
+// `between`, and `since` as a synonym for `after`).
+func (m Messages) Item(r Request, gameID *datastore.Key, channelMembers Nations, limit int) *Item {
 	messageItems := make(List, len(m))
 	for i := range m {
 		messageItems[i] = m[i].Item(r)
@@ -153,13 +334,39 @@ func (m Messages) Item(r Request, gameID *datastore.Key, channelMembers Nations)
 	messagesItem := NewItem(messageItems).SetName("messages").SetDesc([][]string{
 		[]string{
 			"Limiting messages",
-			"Messages normally contain all messages for the chosen channel, but if you provide a `since` query parameter they will only contain new messages since that time.",
+			fmt.Sprintf("Messages normally contain the %d most recent messages for the chosen channel. Use `before`, `after`, `around` or `latest` together with `limit` (defaults to %d, capped at %d) to page through history CHATHISTORY-style (soju/oragono), or `before`+`after` together via `between=<ts1>,<ts2>`. `since` keeps working as a synonym for `after`.", maxMessagesLimit, maxMessagesLimit, maxMessagesLimit),
 		},
 	}).AddLink(r.NewLink(Link{
 		Rel:         "self",
 		Route:       ListMessagesRoute,
 		RouteParams: []string{"game_id", gameID.Encode(), "channel_members", channelMembers.String()},
 	}))
+	if len(m) > 0 {
+		// `m` is ordered newest first (`-CreatedAt`), so `m[0]` is the newest
+		// message in the page and `m[len(m)-1]` the oldest. `ListMessagesRoute`
+		// declares `before`/`after`/`limit` as query matchers, so they can be
+		// filled via `RouteParams` the same way path variables are.
+		messagesItem.AddLink(r.NewLink(Link{
+			Rel:   "next",
+			Route: ListMessagesRoute,
+			RouteParams: []string{
+				"game_id", gameID.Encode(),
+				"channel_members", channelMembers.String(),
+				"before", m[len(m)-1].CreatedAt.Format(time.RFC3339Nano),
+				"limit", strconv.Itoa(limit),
+			},
+		}))
+		messagesItem.AddLink(r.NewLink(Link{
+			Rel:   "prev",
+			Route: ListMessagesRoute,
+			RouteParams: []string{
+				"game_id", gameID.Encode(),
+				"channel_members", channelMembers.String(),
+				"after", m[0].CreatedAt.Format(time.RFC3339Nano),
+				"limit", strconv.Itoa(limit),
+			},
+		}))
+	}
 	return messagesItem
 }
 
@@ -170,6 +377,36 @@ type Message struct {
 	Sender         dip.Nation
 	Body           string `methods:"POST"`
 	CreatedAt      time.Time
+	// Tokens is the write-time full text index for Body: lowercased,
+	// punctuation-stripped words of at least 2 characters, deduped and
+	// capped at maxMessageTokens. `searchMessages` ANDs query words by
+	// chaining repeated `Filter("Tokens=", tok)` calls, which datastore
+	// supports on a single indexed repeated property. It is indexed but not
+	// part of the public API, so it's excluded from JSON responses.
+	Tokens []string `json:"-"`
+}
+
+// tokenizeBody implements the indexing side of full text search: lowercase,
+// split on anything that isn't a letter or digit, drop short tokens, dedupe,
+// and cap at maxMessageTokens.
+func tokenizeBody(body string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(body), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := map[string]bool{}
+	tokens := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if utf8.RuneCountInString(field) < 2 || seen[field] {
+			continue
+		}
+		seen[field] = true
+		tokens = append(tokens, field)
+		if len(tokens) >= maxMessageTokens {
+			break
+		}
+	}
+	return tokens
 }
 
 func (m *Message) Item(r Request) *Item {
@@ -209,6 +446,7 @@ func createMessage(w ResponseWriter, r Request) (*Message, error) {
 	message.GameID = gameID
 	message.Sender = member.Nation
 	message.CreatedAt = time.Now()
+	message.Tokens = tokenizeBody(message.Body)
 	sort.Sort(message.ChannelMembers)
 
 	if !message.ChannelMembers.Includes(member.Nation) {
@@ -228,23 +466,20 @@ func createMessage(w ResponseWriter, r Request) (*Message, error) {
 		return nil, err
 	}
 
-	if err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
-		channel := &Channel{}
-		if err := datastore.Get(ctx, channelID, channel); err == datastore.ErrNoSuchEntity {
-			channel.GameID = gameID
-			channel.Members = message.ChannelMembers
-			channel.NMessages = 0
-		}
-		if message.ID, err = datastore.Put(ctx, datastore.NewIncompleteKey(ctx, messageKind, channelID), message); err != nil {
-			return err
-		}
-		channel.NMessages += 1
-		_, err = datastore.Put(ctx, channelID, channel)
-		return err
-	}, &datastore.TransactionOptions{XG: false}); err != nil {
+	initialChannel := &Channel{
+		GameID:  gameID,
+		Members: message.ChannelMembers,
+	}
+	created, err := messageStore.Put(ctx, channelID, initialChannel, message)
+	if err != nil {
 		return nil, err
 	}
 
+	if created {
+		gameBroker.publish(gameID, StreamEvent{Type: StreamEventChannel, Channel: initialChannel})
+	}
+	gameBroker.publish(gameID, StreamEvent{Type: StreamEventMessage, Message: message})
+
 	return message, nil
 }
 
@@ -274,7 +509,202 @@ func isPublic(variant string, members Nations) bool {
 	return true
 }
 
-func listMessages(w ResponseWriter, r Request) error {
+// parseMessageSelector parses the CHATHISTORY-style query parameters
+// (`before`, `after`, `around`, `latest`, `between`) understood by
+// `listMessages` and `searchMessages` into a MessageStore.List ListOpts,
+// modeled after the selectors soju/oragono implement for the IRCv3
+// CHATHISTORY extension. `limit` defaults to maxMessagesLimit when omitted,
+// so the legacy bare `/Messages/{channel_members}` call and the legacy
+// `since`-only call keep working without it.
+func parseMessageSelector(r Request) (*ListOpts, error) {
+	q := r.Req().URL.Query()
+
+	limit := maxMessagesLimit
+	if limitParam := q.Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("`limit` must be a positive integer")
+		}
+		limit = parsed
+	}
+	if limit > maxMessagesLimit {
+		limit = maxMessagesLimit
+	}
+
+	parseTimeParam := func(key string) (*time.Time, error) {
+		val := q.Get(key)
+		if val == "" {
+			return nil, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, val)
+		if err != nil {
+			return nil, fmt.Errorf("`%s` must be an RFC3339 timestamp", key)
+		}
+		return &t, nil
+	}
+
+	before, err := parseTimeParam("before")
+	if err != nil {
+		return nil, err
+	}
+	after, err := parseTimeParam("after")
+	if err != nil {
+		return nil, err
+	}
+	if after == nil {
+		// `since` is a backward compatible synonym for `after`.
+		after, err = parseTimeParam("since")
+		if err != nil {
+			return nil, err
+		}
+	}
+	around, err := parseTimeParam("around")
+	if err != nil {
+		return nil, err
+	}
+	_, latest := q["latest"]
+
+	var between []time.Time
+	if betweenParam := q.Get("between"); betweenParam != "" {
+		parts := strings.Split(betweenParam, ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("`between` requires exactly two comma separated RFC3339 timestamps")
+		}
+		for _, part := range parts {
+			t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(part))
+			if err != nil {
+				return nil, fmt.Errorf("`between` timestamps must be RFC3339")
+			}
+			between = append(between, t)
+		}
+		if !between[0].Before(between[1]) {
+			return nil, fmt.Errorf("`between` requires the first timestamp to be before the second")
+		}
+	}
+
+	if before != nil && after != nil {
+		return nil, fmt.Errorf("combining `before` and `after` requires the `between` selector with two comma separated timestamps instead")
+	}
+
+	if before == nil && after == nil && around == nil && !latest && between == nil {
+		latest = true
+	}
+
+	return &ListOpts{
+		Before:  before,
+		After:   after,
+		Around:  around,
+		Between: between,
+		Latest:  latest,
+		Limit:   limit,
+	}, nil
+}
+
+// messagesByCreatedAt sorts Messages ascending by CreatedAt, used to merge
+// the per-channel result sets `searchMessages` fetches before reversing to
+// the CreatedAt DESC order the endpoint promises.
+type messagesByCreatedAt Messages
+
+func (m messagesByCreatedAt) Len() int           { return len(m) }
+func (m messagesByCreatedAt) Less(i, j int) bool { return m[i].CreatedAt.Before(m[j].CreatedAt) }
+func (m messagesByCreatedAt) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// parseSearchQuery splits a search query into the tokens used to build the
+// AND'd `Tokens=` datastore filters, and the quoted phrases used for an
+// in-memory substring post-filter (datastore has no native phrase match).
+func parseSearchQuery(query string) (tokens []string, phrases []string) {
+	remainder := query
+	for {
+		start := strings.Index(remainder, `"`)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(remainder[start+1:], `"`)
+		if end < 0 {
+			break
+		}
+		phrase := strings.ToLower(strings.TrimSpace(remainder[start+1 : start+1+end]))
+		if phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+		remainder = remainder[:start] + " " + remainder[start+1+end+1:]
+	}
+
+	all := tokenizeBody(remainder)
+	for _, phrase := range phrases {
+		all = append(all, tokenizeBody(phrase)...)
+	}
+
+	seen := map[string]bool{}
+	for _, tok := range all {
+		if seen[tok] {
+			continue
+		}
+		seen[tok] = true
+		tokens = append(tokens, tok)
+	}
+	return tokens, phrases
+}
+
+// SearchItem renders search results as a Messages item with a `search`
+// self-link, carrying the active filters forward into `next`/`prev` links
+// the same way `Messages.Item` does for plain channel history.
+func (m Messages) SearchItem(r Request, gameID *datastore.Key, query, sender, channelMembers string, from, to *time.Time, limit int) *Item {
+	messageItems := make(List, len(m))
+	for i := range m {
+		messageItems[i] = m[i].Item(r)
+	}
+	searchItem := NewItem(messageItems).SetName("messages").SetDesc([][]string{
+		[]string{
+			"Full text search",
+			"Results are scoped to channels you belong to (plus the public channel), ordered newest first. Query words are ANDed against the write-time token index; quote a phrase for an exact substring match on top of that.",
+		},
+	})
+
+	params := []string{"game_id", gameID.Encode(), "q", query}
+	if sender != "" {
+		params = append(params, "sender", sender)
+	}
+	if channelMembers != "" {
+		params = append(params, "channel_members", channelMembers)
+	}
+	if from != nil {
+		params = append(params, "from", from.Format(time.RFC3339))
+	}
+	if to != nil {
+		params = append(params, "to", to.Format(time.RFC3339))
+	}
+
+	searchItem.AddLink(r.NewLink(Link{
+		Rel:         "search",
+		Route:       SearchMessagesRoute,
+		RouteParams: params,
+	}))
+
+	if len(m) > 0 {
+		withLimit := func(extra ...string) []string {
+			out := append([]string{}, params...)
+			return append(append(out, extra...), "limit", strconv.Itoa(limit))
+		}
+		searchItem.AddLink(r.NewLink(Link{
+			Rel:         "next",
+			Route:       SearchMessagesRoute,
+			RouteParams: withLimit("before", m[len(m)-1].CreatedAt.Format(time.RFC3339Nano)),
+		}))
+		searchItem.AddLink(r.NewLink(Link{
+			Rel:         "prev",
+			Route:       SearchMessagesRoute,
+			RouteParams: withLimit("after", m[0].CreatedAt.Format(time.RFC3339Nano)),
+		}))
+	}
+
+	return searchItem
+}
+
+// searchMessages implements `GET /Game/{game_id}/Messages/Search`, scoped to
+// channels the caller is a member of (plus the public channel). It is
+// registered as SearchMessagesRoute alongside ListMessagesRoute.
+func searchMessages(w ResponseWriter, r Request) error {
 	ctx := appengine.NewContext(r.Req())
 
 	user, ok := r.Values()["user"].(*auth.User)
@@ -288,16 +718,155 @@ func listMessages(w ResponseWriter, r Request) error {
 		return err
 	}
 
-	channelMembers := Nations{}
-	channelMembers.FromString(r.Vars()["channel_members"])
+	memberID, err := MemberID(ctx, gameID, user.Id)
+	if err != nil {
+		return err
+	}
 
-	var since *time.Time
-	if sinceParam := r.Req().URL.Query().Get("since"); sinceParam != "" {
-		sinceTime, err := time.Parse(time.RFC3339, sinceParam)
+	var nation dip.Nation
+
+	game := &Game{}
+	member := &Member{}
+	err = datastore.GetMulti(ctx, []*datastore.Key{gameID, memberID}, []interface{}{game, member})
+	if err == nil {
+		nation = member.Nation
+	} else if merr, ok := err.(appengine.MultiError); ok {
+		if merr[0] != nil {
+			return merr[0]
+		}
+	} else {
+		return err
+	}
+
+	q := r.Req().URL.Query()
+
+	query := q.Get("q")
+	if query == "" {
+		http.Error(w, "`q` is required", 400)
+		return nil
+	}
+
+	sel, err := parseMessageSelector(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil
+	}
+
+	sender := q.Get("sender")
+
+	var from, to *time.Time
+	if fromParam := q.Get("from"); fromParam != "" {
+		t, err := time.Parse(time.RFC3339, fromParam)
 		if err != nil {
 			return err
 		}
-		since = &sinceTime
+		from = &t
+	}
+	if toParam := q.Get("to"); toParam != "" {
+		t, err := time.Parse(time.RFC3339, toParam)
+		if err != nil {
+			return err
+		}
+		to = &t
+	}
+
+	channelMembersParam := q.Get("channel_members")
+
+	channelIDs := []*datastore.Key{}
+	if channelMembersParam != "" {
+		members := Nations{}
+		members.FromString(channelMembersParam)
+		if !members.Includes(nation) && !isPublic(game.Variant, members) {
+			http.Error(w, "can only search member channels", 403)
+			return nil
+		}
+		channelID, err := ChannelID(ctx, gameID, members)
+		if err != nil {
+			return err
+		}
+		channelIDs = append(channelIDs, channelID)
+	} else {
+		channelIDs, err = callerChannelIDs(ctx, gameID, game, nation)
+		if err != nil {
+			return err
+		}
+	}
+
+	tokens, phrases := parseSearchQuery(query)
+
+	messages := Messages{}
+	for _, channelID := range channelIDs {
+		channelMessages, err := messageStore.SearchChannel(ctx, gameID, SearchFilter{
+			ChannelID: channelID,
+			Tokens:    tokens,
+			Phrases:   phrases,
+			Sender:    dip.Nation(sender),
+			From:      from,
+			To:        to,
+			Before:    sel.Before,
+			After:     sel.After,
+		})
+		if err != nil {
+			return err
+		}
+		messages = append(messages, channelMessages...)
+	}
+
+	sort.Sort(sort.Reverse(messagesByCreatedAt(messages)))
+
+	if len(phrases) > 0 {
+		filtered := messages[:0]
+		for _, m := range messages {
+			body := strings.ToLower(m.Body)
+			matched := true
+			for _, phrase := range phrases {
+				if !strings.Contains(body, phrase) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				filtered = append(filtered, m)
+			}
+		}
+		messages = filtered
+	}
+
+	if len(messages) > sel.Limit {
+		if sel.After != nil {
+			// Like `after` in listMessages: the oldest `limit` matches newer
+			// than the cursor, still ordered newest first.
+			messages = messages[len(messages)-sel.Limit:]
+		} else {
+			messages = messages[:sel.Limit]
+		}
+	}
+
+	w.SetContent(messages.SearchItem(r, gameID, query, sender, channelMembersParam, from, to, sel.Limit))
+	return nil
+}
+
+func listMessages(w ResponseWriter, r Request) error {
+	ctx := appengine.NewContext(r.Req())
+
+	user, ok := r.Values()["user"].(*auth.User)
+	if !ok {
+		http.Error(w, "unauthorized", 401)
+		return nil
+	}
+
+	gameID, err := datastore.DecodeKey(r.Vars()["game_id"])
+	if err != nil {
+		return err
+	}
+
+	channelMembers := Nations{}
+	channelMembers.FromString(r.Vars()["channel_members"])
+
+	sel, err := parseMessageSelector(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil
 	}
 
 	memberID, err := MemberID(ctx, gameID, user.Id)
@@ -330,16 +899,21 @@ func listMessages(w ResponseWriter, r Request) error {
 		return err
 	}
 
-	messages := Messages{}
-	q := datastore.NewQuery(messageKind).Ancestor(channelID)
-	if since != nil {
-		q = q.Filter("CreatedAt>", *since)
-	}
-	if _, err := q.Order("-CreatedAt").GetAll(ctx, &messages); err != nil {
+	messages, err := messageStore.List(ctx, channelID, *sel)
+	if err != nil {
 		return err
 	}
 
-	w.SetContent(messages.Item(r, gameID, channelMembers))
+	if sel.Latest && len(messages) > 0 {
+		// Reading the tail of the channel without an explicit history
+		// selector counts as catching up, so advance the caller's read
+		// marker to the newest message now visible to them.
+		if _, err := setReadMarker(ctx, gameID, channelID, user.Id, messages[0].CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	w.SetContent(messages.Item(r, gameID, channelMembers, sel.Limit))
 	return nil
 }
 
@@ -422,8 +996,42 @@ func listChannels(w ResponseWriter, r Request) error {
 			return merr
 		}
 	} else {
+		// No explicit `since`: fall back to the caller's stored read marker
+		// for each channel so clients get a real unread count without having
+		// to track their own cursor.
+		results := make(chan error)
 		for i := range channels {
-			channels[i].NMessagesSince.NMessages = channels[i].NMessages
+			go func(c *Channel) {
+				channelID, err := c.ID(ctx)
+				if err != nil {
+					results <- err
+					return
+				}
+				markerID, err := ReadMarkerID(ctx, channelID, user.Id)
+				if err != nil {
+					results <- err
+					return
+				}
+				marker := &ReadMarker{}
+				if err := datastore.Get(ctx, markerID, marker); err == datastore.ErrNoSuchEntity {
+					c.NMessagesSince.NMessages = c.NMessages
+					results <- nil
+					return
+				} else if err != nil {
+					results <- err
+					return
+				}
+				results <- c.CountSince(ctx, marker.LastRead)
+			}(&channels[i])
+		}
+		merr := appengine.MultiError{}
+		for _ = range channels {
+			if err := <-results; err != nil {
+				merr = append(merr, err)
+			}
+		}
+		if len(merr) > 0 {
+			return merr
 		}
 	}
 
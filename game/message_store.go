@@ -0,0 +1,95 @@
+package game
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	dip "github.com/zond/godip/common"
+)
+
+// messageStoreDriverEnv selects which MessageStore implementation backs the
+// game package, the same way soju picks a `logs {fs,memory,db}` driver.
+// Unset or "datastore" keeps the appengine/datastore backed store that has
+// always been used here; "memory" swaps in an in-process store, which is
+// what lets press/chat logic be unit-tested without a datastore emulator and
+// is the shape a future SQL backend for self-hosters would also take.
+const messageStoreDriverEnv = "DIPLICITY_MESSAGE_STORE_DRIVER"
+
+// ListOpts is the parsed form of the CHATHISTORY-style selectors
+// (`before`, `after`, `around`, `latest`, `between`) a MessageStore.List call
+// understands, modeled after the selectors soju/oragono implement for the
+// IRCv3 CHATHISTORY extension.
+type ListOpts struct {
+	Before  *time.Time
+	After   *time.Time
+	Around  *time.Time
+	Between []time.Time
+	Latest  bool
+	Limit   int
+}
+
+// SearchFilter scopes a MessageStore.SearchChannel call: Tokens are ANDed
+// exactly like they are at write time, Phrases is an additional in-memory
+// substring post-filter for quoted multi-word queries, and Before/After page
+// through results the same way ListOpts does.
+type SearchFilter struct {
+	ChannelID *datastore.Key
+	Tokens    []string
+	Phrases   []string
+	Sender    dip.Nation
+	From      *time.Time
+	To        *time.Time
+	Before    *time.Time
+	After     *time.Time
+	Limit     int
+}
+
+// MessageStore is the storage seam between the press/chat HTTP handlers in
+// this package and the datastore they persist to, following the pattern
+// soju uses for its pluggable log drivers. The default implementation is
+// backed by appengine/datastore; an in-memory implementation is provided for
+// tests and for running without a `dev_appserver` datastore emulator.
+type MessageStore interface {
+	// Put stores msg in channelID, lazily creating the channel (seeded from
+	// `initialChannel` when it does not exist yet) and incrementing its
+	// NMessages counter in the same transaction. The returned bool reports
+	// whether the channel was just created, so callers can fan out a
+	// `channel` event alongside the `message` one.
+	Put(ctx context.Context, channelID *datastore.Key, initialChannel *Channel, msg *Message) (channelCreated bool, err error)
+	// List returns the page of messages described by opts, newest first.
+	List(ctx context.Context, channelID *datastore.Key, opts ListOpts) (Messages, error)
+	// CountSince returns how many messages in channelID were created after t.
+	CountSince(ctx context.Context, channelID *datastore.Key, t time.Time) (int, error)
+	// SearchChannel returns the messages in filter.ChannelID matching filter,
+	// newest first.
+	SearchChannel(ctx context.Context, gameID *datastore.Key, filter SearchFilter) (Messages, error)
+}
+
+func newMessageStore(driver string) MessageStore {
+	switch driver {
+	case "", "datastore":
+		return &datastoreMessageStore{}
+	case "memory":
+		return newMemoryMessageStore()
+	default:
+		panic(fmt.Sprintf("game: unknown %s %q", messageStoreDriverEnv, driver))
+	}
+}
+
+// messageStore is the active MessageStore, selected once at package init
+// time via messageStoreDriverEnv. Tests that want the in-memory driver
+// without setting the environment variable can call SetMessageStore.
+var messageStore = newMessageStore(os.Getenv(messageStoreDriverEnv))
+
+// SetMessageStore overrides the active MessageStore, for tests that want the
+// in-memory driver (or a fake) regardless of messageStoreDriverEnv. It
+// returns the previously active store so callers can restore it.
+func SetMessageStore(s MessageStore) MessageStore {
+	prev := messageStore
+	messageStore = s
+	return prev
+}
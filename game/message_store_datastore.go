@@ -0,0 +1,149 @@
+package game
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// datastoreMessageStore is the default MessageStore, backed by
+// appengine/datastore exactly like this package always has been.
+type datastoreMessageStore struct{}
+
+func (s *datastoreMessageStore) Put(ctx context.Context, channelID *datastore.Key, initialChannel *Channel, msg *Message) (bool, error) {
+	created := false
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		channel := &Channel{}
+		if err := datastore.Get(ctx, channelID, channel); err == datastore.ErrNoSuchEntity {
+			*channel = *initialChannel
+			created = true
+		} else if err != nil {
+			return err
+		} else {
+			created = false
+		}
+
+		var err error
+		if msg.ID, err = datastore.Put(ctx, datastore.NewIncompleteKey(ctx, messageKind, channelID), msg); err != nil {
+			return err
+		}
+		channel.NMessages += 1
+		_, err = datastore.Put(ctx, channelID, channel)
+		return err
+	}, &datastore.TransactionOptions{XG: false})
+	return created, err
+}
+
+func (s *datastoreMessageStore) CountSince(ctx context.Context, channelID *datastore.Key, t time.Time) (int, error) {
+	return datastore.NewQuery(messageKind).Ancestor(channelID).Filter("CreatedAt>", t).Count(ctx)
+}
+
+func (s *datastoreMessageStore) List(ctx context.Context, channelID *datastore.Key, opts ListOpts) (Messages, error) {
+	switch {
+	case opts.Between != nil:
+		messages := Messages{}
+		_, err := datastore.NewQuery(messageKind).
+			Ancestor(channelID).
+			Filter("CreatedAt>", opts.Between[0]).
+			Filter("CreatedAt<", opts.Between[1]).
+			Order("-CreatedAt").
+			Limit(opts.Limit).
+			GetAll(ctx, &messages)
+		return messages, err
+	case opts.Before != nil:
+		messages := Messages{}
+		_, err := datastore.NewQuery(messageKind).
+			Ancestor(channelID).
+			Filter("CreatedAt<", *opts.Before).
+			Order("-CreatedAt").
+			Limit(opts.Limit).
+			GetAll(ctx, &messages)
+		return messages, err
+	case opts.After != nil:
+		// The oldest `limit` messages strictly newer than `After`, returned
+		// newest first like every other selector.
+		messages := Messages{}
+		_, err := datastore.NewQuery(messageKind).
+			Ancestor(channelID).
+			Filter("CreatedAt>", *opts.After).
+			Order("CreatedAt").
+			Limit(opts.Limit).
+			GetAll(ctx, &messages)
+		if err != nil {
+			return nil, err
+		}
+		reverseMessages(messages)
+		return messages, nil
+	case opts.Around != nil:
+		before := opts.Limit / 2
+		after := opts.Limit - before
+
+		older := Messages{}
+		if _, err := datastore.NewQuery(messageKind).
+			Ancestor(channelID).
+			Filter("CreatedAt<=", *opts.Around).
+			Order("-CreatedAt").
+			Limit(before).
+			GetAll(ctx, &older); err != nil {
+			return nil, err
+		}
+
+		newer := Messages{}
+		if _, err := datastore.NewQuery(messageKind).
+			Ancestor(channelID).
+			Filter("CreatedAt>", *opts.Around).
+			Order("CreatedAt").
+			Limit(after).
+			GetAll(ctx, &newer); err != nil {
+			return nil, err
+		}
+		reverseMessages(newer)
+
+		messages := make(Messages, 0, len(older)+len(newer))
+		messages = append(messages, newer...)
+		messages = append(messages, older...)
+		return messages, nil
+	default:
+		// `Latest`.
+		messages := Messages{}
+		_, err := datastore.NewQuery(messageKind).
+			Ancestor(channelID).
+			Order("-CreatedAt").
+			Limit(opts.Limit).
+			GetAll(ctx, &messages)
+		return messages, err
+	}
+}
+
+func (s *datastoreMessageStore) SearchChannel(ctx context.Context, gameID *datastore.Key, filter SearchFilter) (Messages, error) {
+	q := datastore.NewQuery(messageKind).Ancestor(filter.ChannelID)
+	for _, tok := range filter.Tokens {
+		q = q.Filter("Tokens=", tok)
+	}
+	if filter.Sender != "" {
+		q = q.Filter("Sender=", filter.Sender)
+	}
+	if filter.From != nil {
+		q = q.Filter("CreatedAt>=", *filter.From)
+	}
+	if filter.To != nil {
+		q = q.Filter("CreatedAt<=", *filter.To)
+	}
+	if filter.Before != nil {
+		q = q.Filter("CreatedAt<", *filter.Before)
+	}
+	if filter.After != nil {
+		q = q.Filter("CreatedAt>", *filter.After)
+	}
+
+	messages := Messages{}
+	_, err := q.Order("-CreatedAt").GetAll(ctx, &messages)
+	return messages, err
+}
+
+func reverseMessages(m Messages) {
+	for i, j := 0, len(m)-1; i < j; i, j = i+1, j-1 {
+		m[i], m[j] = m[j], m[i]
+	}
+}
@@ -0,0 +1,215 @@
+package game
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// memoryMessageStore is an in-process MessageStore for tests and for local
+// runs without a dev_appserver datastore emulator. Everything lives in
+// memory for the lifetime of the process; nothing survives a restart.
+type memoryMessageStore struct {
+	mu       sync.Mutex
+	channels map[string]*Channel
+	messages map[string][]*Message
+	nextID   int64
+}
+
+func newMemoryMessageStore() *memoryMessageStore {
+	return &memoryMessageStore{
+		channels: map[string]*Channel{},
+		messages: map[string][]*Message{},
+	}
+}
+
+func (s *memoryMessageStore) ensureChannelLocked(channelID *datastore.Key, initial *Channel) *Channel {
+	key := channelID.Encode()
+	channel, ok := s.channels[key]
+	if !ok {
+		copied := *initial
+		channel = &copied
+		s.channels[key] = channel
+	}
+	return channel
+}
+
+func (s *memoryMessageStore) Put(ctx context.Context, channelID *datastore.Key, initialChannel *Channel, msg *Message) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := channelID.Encode()
+	_, existed := s.channels[key]
+	channel := s.ensureChannelLocked(channelID, initialChannel)
+
+	s.nextID++
+	msg.ID = datastore.NewKey(ctx, messageKind, "", s.nextID, channelID)
+
+	stored := *msg
+	s.messages[key] = append(s.messages[key], &stored)
+	channel.NMessages += 1
+
+	return !existed, nil
+}
+
+func (s *memoryMessageStore) CountSince(ctx context.Context, channelID *datastore.Key, t time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, m := range s.messages[channelID.Encode()] {
+		if m.CreatedAt.After(t) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryMessageStore) snapshotAscending(channelID *datastore.Key) []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := append([]*Message{}, s.messages[channelID.Encode()]...)
+	sort.Slice(all, func(i, j int) bool { return all[i].CreatedAt.Before(all[j].CreatedAt) })
+	return all
+}
+
+func (s *memoryMessageStore) List(ctx context.Context, channelID *datastore.Key, opts ListOpts) (Messages, error) {
+	all := s.snapshotAscending(channelID)
+
+	var page []*Message
+	switch {
+	case opts.Between != nil:
+		var inRange []*Message
+		for _, m := range all {
+			if m.CreatedAt.After(opts.Between[0]) && m.CreatedAt.Before(opts.Between[1]) {
+				inRange = append(inRange, m)
+			}
+		}
+		page = reverseMessagePtrs(tailMessagePtrs(inRange, opts.Limit))
+	case opts.Before != nil:
+		var older []*Message
+		for _, m := range all {
+			if m.CreatedAt.Before(*opts.Before) {
+				older = append(older, m)
+			}
+		}
+		page = reverseMessagePtrs(tailMessagePtrs(older, opts.Limit))
+	case opts.After != nil:
+		var newer []*Message
+		for _, m := range all {
+			if m.CreatedAt.After(*opts.After) {
+				newer = append(newer, m)
+			}
+		}
+		page = reverseMessagePtrs(headMessagePtrs(newer, opts.Limit))
+	case opts.Around != nil:
+		var older, newer []*Message
+		for _, m := range all {
+			if m.CreatedAt.After(*opts.Around) {
+				newer = append(newer, m)
+			} else {
+				older = append(older, m)
+			}
+		}
+		before := opts.Limit / 2
+		after := opts.Limit - before
+		older = reverseMessagePtrs(tailMessagePtrs(older, before))
+		newer = reverseMessagePtrs(headMessagePtrs(newer, after))
+		page = append(append([]*Message{}, newer...), older...)
+	default:
+		// `Latest`.
+		page = reverseMessagePtrs(tailMessagePtrs(all, opts.Limit))
+	}
+
+	return messagePtrsToValues(page), nil
+}
+
+func (s *memoryMessageStore) SearchChannel(ctx context.Context, gameID *datastore.Key, filter SearchFilter) (Messages, error) {
+	all := s.snapshotAscending(filter.ChannelID)
+
+	tokenSet := map[string]bool{}
+	for _, tok := range filter.Tokens {
+		tokenSet[tok] = true
+	}
+
+	var matched []*Message
+	for _, m := range all {
+		if filter.Sender != "" && m.Sender != filter.Sender {
+			continue
+		}
+		if filter.From != nil && m.CreatedAt.Before(*filter.From) {
+			continue
+		}
+		if filter.To != nil && m.CreatedAt.After(*filter.To) {
+			continue
+		}
+		if filter.Before != nil && !m.CreatedAt.Before(*filter.Before) {
+			continue
+		}
+		if filter.After != nil && !m.CreatedAt.After(*filter.After) {
+			continue
+		}
+		if len(tokenSet) > 0 {
+			have := map[string]bool{}
+			for _, tok := range m.Tokens {
+				have[tok] = true
+			}
+			hasAll := true
+			for tok := range tokenSet {
+				if !have[tok] {
+					hasAll = false
+					break
+				}
+			}
+			if !hasAll {
+				continue
+			}
+		}
+		matched = append(matched, m)
+	}
+
+	page := reverseMessagePtrs(matched)
+	if filter.Limit > 0 && len(page) > filter.Limit {
+		if filter.After != nil {
+			page = page[len(page)-filter.Limit:]
+		} else {
+			page = page[:filter.Limit]
+		}
+	}
+
+	return messagePtrsToValues(page), nil
+}
+
+func tailMessagePtrs(s []*Message, n int) []*Message {
+	if n >= len(s) {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+func headMessagePtrs(s []*Message, n int) []*Message {
+	if n >= len(s) {
+		return s
+	}
+	return s[:n]
+}
+
+func reverseMessagePtrs(s []*Message) []*Message {
+	out := make([]*Message, len(s))
+	for i := range s {
+		out[i] = s[len(s)-1-i]
+	}
+	return out
+}
+
+func messagePtrsToValues(s []*Message) Messages {
+	messages := make(Messages, len(s))
+	for i, m := range s {
+		messages[i] = *m
+	}
+	return messages
+}
@@ -0,0 +1,264 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func newTestChannelID(ctx context.Context, t *testing.T, name string) *datastore.Key {
+	t.Helper()
+	return datastore.NewKey(ctx, channelKind, name, 0, nil)
+}
+
+func seedMessages(ctx context.Context, t *testing.T, s *memoryMessageStore, channelID *datastore.Key, times []time.Time) Messages {
+	t.Helper()
+	initial := &Channel{GameID: channelID, Members: Nations{"England", "France"}}
+	seeded := make(Messages, len(times))
+	for i, at := range times {
+		msg := &Message{
+			Body:      "hello world",
+			Sender:    "England",
+			CreatedAt: at,
+			Tokens:    tokenizeBody("hello world"),
+		}
+		if _, err := s.Put(ctx, channelID, initial, msg); err != nil {
+			t.Fatalf("Put(%d): %v", i, err)
+		}
+		seeded[i] = *msg
+	}
+	return seeded
+}
+
+func TestMemoryMessageStorePut(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryMessageStore()
+	channelID := newTestChannelID(ctx, t, "put-channel")
+	initial := &Channel{GameID: channelID, Members: Nations{"England", "France"}}
+
+	created, err := s.Put(ctx, channelID, initial, &Message{Body: "first", CreatedAt: time.Unix(0, 0)})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !created {
+		t.Errorf("first Put into a new channel: created = false, want true")
+	}
+
+	created, err = s.Put(ctx, channelID, initial, &Message{Body: "second", CreatedAt: time.Unix(1, 0)})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if created {
+		t.Errorf("second Put into an existing channel: created = true, want false")
+	}
+
+	channel := s.channels[channelID.Encode()]
+	if channel == nil {
+		t.Fatalf("channel not stored")
+	}
+	if channel.NMessages != 2 {
+		t.Errorf("NMessages = %d, want 2", channel.NMessages)
+	}
+}
+
+func TestMemoryMessageStoreListSelectors(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Two messages share the same wall-clock second but differ in
+	// sub-second precision, the case the RFC3339Nano cursor fix exists for.
+	times := []time.Time{
+		base,
+		base.Add(100 * time.Millisecond),
+		base.Add(time.Second),
+		base.Add(2 * time.Second),
+		base.Add(3 * time.Second),
+	}
+
+	newSeeded := func(t *testing.T) (*memoryMessageStore, *datastore.Key, Messages) {
+		s := newMemoryMessageStore()
+		channelID := newTestChannelID(ctx, t, "list-channel")
+		seeded := seedMessages(ctx, t, s, channelID, times)
+		return s, channelID, seeded
+	}
+
+	t.Run("latest respects limit and newest-first order", func(t *testing.T) {
+		s, channelID, seeded := newSeeded(t)
+		got, err := s.List(ctx, channelID, ListOpts{Latest: true, Limit: 2})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		want := Messages{seeded[4], seeded[3]}
+		assertSameCreatedAt(t, got, want)
+	})
+
+	t.Run("before is exclusive", func(t *testing.T) {
+		s, channelID, seeded := newSeeded(t)
+		got, err := s.List(ctx, channelID, ListOpts{Before: &seeded[2].CreatedAt, Limit: 10})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		want := Messages{seeded[1], seeded[0]}
+		assertSameCreatedAt(t, got, want)
+	})
+
+	t.Run("after is exclusive and orders newest-first", func(t *testing.T) {
+		s, channelID, seeded := newSeeded(t)
+		got, err := s.List(ctx, channelID, ListOpts{After: &seeded[2].CreatedAt, Limit: 10})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		want := Messages{seeded[4], seeded[3]}
+		assertSameCreatedAt(t, got, want)
+	})
+
+	t.Run("after distinguishes sub-second timestamps", func(t *testing.T) {
+		s, channelID, seeded := newSeeded(t)
+		got, err := s.List(ctx, channelID, ListOpts{After: &seeded[0].CreatedAt, Limit: 10})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		// seeded[1] is 100ms after seeded[0] in the same wall-clock second;
+		// an `After` cursor with second-only precision would either drop it
+		// (strict <) or be unable to tell it apart from seeded[0].
+		want := Messages{seeded[4], seeded[3], seeded[2], seeded[1]}
+		assertSameCreatedAt(t, got, want)
+	})
+
+	t.Run("between excludes both endpoints", func(t *testing.T) {
+		s, channelID, seeded := newSeeded(t)
+		got, err := s.List(ctx, channelID, ListOpts{Between: []time.Time{seeded[0].CreatedAt, seeded[3].CreatedAt}, Limit: 10})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		want := Messages{seeded[2], seeded[1]}
+		assertSameCreatedAt(t, got, want)
+	})
+
+	t.Run("around splits the limit across older and newer halves", func(t *testing.T) {
+		s, channelID, seeded := newSeeded(t)
+		around := seeded[2].CreatedAt
+		got, err := s.List(ctx, channelID, ListOpts{Around: &around, Limit: 4})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		// limit 4 -> 2 older (<=around), 2 newer (>around), newest-first.
+		want := Messages{seeded[4], seeded[3], seeded[2], seeded[1]}
+		assertSameCreatedAt(t, got, want)
+	})
+}
+
+func TestMemoryMessageStoreCountSince(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryMessageStore()
+	channelID := newTestChannelID(ctx, t, "count-channel")
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	seeded := seedMessages(ctx, t, s, channelID, []time.Time{base, base.Add(time.Second), base.Add(2 * time.Second)})
+
+	count, err := s.CountSince(ctx, channelID, seeded[0].CreatedAt)
+	if err != nil {
+		t.Fatalf("CountSince: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("CountSince = %d, want 2", count)
+	}
+
+	count, err = s.CountSince(ctx, channelID, seeded[2].CreatedAt)
+	if err != nil {
+		t.Fatalf("CountSince: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("CountSince after the last message = %d, want 0", count)
+	}
+}
+
+func TestMemoryMessageStoreSearchChannel(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryMessageStore()
+	channelID := newTestChannelID(ctx, t, "search-channel")
+	initial := &Channel{GameID: channelID, Members: Nations{"England", "France"}}
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	messages := []*Message{
+		{Body: "the fleet moves to the channel", Sender: "England", CreatedAt: base},
+		{Body: "the army holds burgundy", Sender: "France", CreatedAt: base.Add(time.Second)},
+		{Body: "the fleet holds the channel", Sender: "France", CreatedAt: base.Add(2 * time.Second)},
+	}
+	for _, m := range messages {
+		m.Tokens = tokenizeBody(m.Body)
+		if _, err := s.Put(ctx, channelID, initial, m); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	t.Run("tokens are ANDed", func(t *testing.T) {
+		got, err := s.SearchChannel(ctx, channelID, SearchFilter{ChannelID: channelID, Tokens: []string{"fleet", "holds"}})
+		if err != nil {
+			t.Fatalf("SearchChannel: %v", err)
+		}
+		if len(got) != 1 || got[0].Body != "the fleet holds the channel" {
+			t.Errorf("got %+v, want only the message containing both tokens", got)
+		}
+	})
+
+	t.Run("sender filters", func(t *testing.T) {
+		got, err := s.SearchChannel(ctx, channelID, SearchFilter{ChannelID: channelID, Sender: "France"})
+		if err != nil {
+			t.Fatalf("SearchChannel: %v", err)
+		}
+		if len(got) != 2 {
+			t.Errorf("len(got) = %d, want 2", len(got))
+		}
+		for _, m := range got {
+			if m.Sender != "France" {
+				t.Errorf("got sender %q, want France", m.Sender)
+			}
+		}
+	})
+
+	t.Run("from/to bound the time range inclusively", func(t *testing.T) {
+		from := messages[1].CreatedAt
+		to := messages[1].CreatedAt
+		got, err := s.SearchChannel(ctx, channelID, SearchFilter{ChannelID: channelID, From: &from, To: &to})
+		if err != nil {
+			t.Fatalf("SearchChannel: %v", err)
+		}
+		if len(got) != 1 || got[0].Body != messages[1].Body {
+			t.Errorf("got %+v, want only messages[1]", got)
+		}
+	})
+
+	t.Run("results are newest first", func(t *testing.T) {
+		got, err := s.SearchChannel(ctx, channelID, SearchFilter{ChannelID: channelID})
+		if err != nil {
+			t.Fatalf("SearchChannel: %v", err)
+		}
+		for i := 1; i < len(got); i++ {
+			if !got[i-1].CreatedAt.After(got[i].CreatedAt) {
+				t.Errorf("results not strictly newest-first at index %d", i)
+			}
+		}
+	})
+}
+
+func assertSameCreatedAt(t *testing.T, got, want Messages) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("len(got) = %d, len(want) = %d (got=%v want=%v)", len(got), len(want), createdAtsOf(got), createdAtsOf(want))
+	}
+	for i := range got {
+		if !got[i].CreatedAt.Equal(want[i].CreatedAt) {
+			t.Errorf("at index %d: got %v, want %v", i, createdAtsOf(got), createdAtsOf(want))
+			break
+		}
+	}
+}
+
+func createdAtsOf(m Messages) []time.Time {
+	out := make([]time.Time, len(m))
+	for i := range m {
+		out[i] = m[i].CreatedAt
+	}
+	return out
+}
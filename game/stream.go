@@ -0,0 +1,330 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zond/diplicity/auth"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	. "github.com/zond/goaeoas"
+	dip "github.com/zond/godip/common"
+)
+
+// streamTimeout is how long a long-poll fallback blocks for a new event
+// before responding with an empty batch.
+const streamTimeout = 30 * time.Second
+
+const (
+	// StreamMessagesRoute names the GET /Game/{game_id}/Messages/Stream
+	// route streamMessages implements, and StreamChannelsRoute names the
+	// GET /Game/{game_id}/Channels/Stream route streamChannels implements.
+	// Like SearchMessagesRoute in chat.go, binding these names (and the
+	// handlers themselves) to their path/method happens in the package's
+	// central resource registration, which is outside this snapshot.
+	StreamMessagesRoute = "StreamMessages"
+	StreamChannelsRoute = "StreamChannels"
+)
+
+// StreamBatch is what the long-poll fallback of streamMessages/streamChannels
+// responds with: the whole Last-Event-ID backfill when there is one,
+// otherwise the first visible live event to arrive within streamTimeout, or
+// no event at all when none arrives.
+type StreamBatch struct {
+	Events []StreamEvent
+}
+
+func (b StreamBatch) Item(r Request, gameID *datastore.Key, route string) *Item {
+	return NewItem(b).SetName("stream").AddLink(r.NewLink(Link{
+		Rel:         "self",
+		Route:       route,
+		RouteParams: []string{"game_id", gameID.Encode()},
+	}))
+}
+
+func wantsEventStream(r Request) bool {
+	return strings.Contains(r.Req().Header.Get("Accept"), "text/event-stream")
+}
+
+// callerChannelIDs returns the channels `nation` belongs to in gameID, plus
+// the public channel, deduped. It is shared by searchMessages (to scope a
+// search) and streamMessages (to scope a Last-Event-ID backfill).
+func callerChannelIDs(ctx context.Context, gameID *datastore.Key, game *Game, nation dip.Nation) ([]*datastore.Key, error) {
+	publicChannelID, err := ChannelID(ctx, gameID, publicChannel(game.Variant))
+	if err != nil {
+		return nil, err
+	}
+	channelIDs := []*datastore.Key{publicChannelID}
+
+	if nation != "" {
+		memberChannels := Channels{}
+		if _, err := datastore.NewQuery(channelKind).Filter("GameID=", gameID).Filter("Members=", nation).GetAll(ctx, &memberChannels); err != nil {
+			return nil, err
+		}
+		for i := range memberChannels {
+			channelID, err := memberChannels[i].ID(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if channelID.Encode() != publicChannelID.Encode() {
+				channelIDs = append(channelIDs, channelID)
+			}
+		}
+	}
+
+	return channelIDs, nil
+}
+
+// streamEvents drives a single subscriber connection: SSE when the client
+// sends `Accept: text/event-stream`, otherwise a long-poll that blocks up to
+// streamTimeout for a single matching event. `backfill` is emitted first
+// (SSE) or returned immediately (long-poll), ahead of any live event.
+func streamEvents(w ResponseWriter, r Request, gameID *datastore.Key, route string, visible func(StreamEvent) bool, backfill []StreamEvent) error {
+	ch := gameBroker.subscribe(gameID)
+	defer gameBroker.unsubscribe(gameID, ch)
+
+	if wantsEventStream(r) {
+		flusher, canFlush := w.(http.Flusher)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		for _, event := range backfill {
+			if err := writeSSEEvent(w, event); err != nil {
+				return nil
+			}
+		}
+		if canFlush {
+			flusher.Flush()
+		}
+
+		done := r.Req().Context().Done()
+		for {
+			select {
+			case event, ok := <-ch:
+				if !ok {
+					return nil
+				}
+				if !visible(event) {
+					continue
+				}
+				if err := writeSSEEvent(w, event); err != nil {
+					return nil
+				}
+				if canFlush {
+					flusher.Flush()
+				}
+			case <-done:
+				return nil
+			}
+		}
+	}
+
+	if len(backfill) > 0 {
+		w.SetContent(StreamBatch{Events: backfill}.Item(r, gameID, route))
+		return nil
+	}
+
+	timer := time.NewTimer(streamTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				w.SetContent(StreamBatch{}.Item(r, gameID, route))
+				return nil
+			}
+			if !visible(event) {
+				continue
+			}
+			w.SetContent(StreamBatch{Events: []StreamEvent{event}}.Item(r, gameID, route))
+			return nil
+		case <-timer.C:
+			w.SetContent(StreamBatch{}.Item(r, gameID, route))
+			return nil
+		}
+	}
+}
+
+func writeSSEEvent(w ResponseWriter, event StreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var id string
+	switch event.Type {
+	case StreamEventMessage:
+		if event.Message != nil {
+			id = event.Message.CreatedAt.Format(time.RFC3339Nano)
+		}
+	case StreamEventReadMarker:
+		if event.ReadMarker != nil {
+			id = event.ReadMarker.LastRead.Format(time.RFC3339Nano)
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\n", event.Type); err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+// messagesToEvents wraps messages (newest first, as returned by
+// MessageStore.List) as `message` StreamEvents in the same order.
+func messagesToEvents(messages Messages) []StreamEvent {
+	events := make([]StreamEvent, len(messages))
+	for i := range messages {
+		msg := messages[i]
+		events[i] = StreamEvent{Type: StreamEventMessage, Message: &msg}
+	}
+	return events
+}
+
+// streamMessages implements `GET /Game/{game_id}/Messages/Stream`: new
+// `message` events for channels the caller belongs to (plus the public
+// channel), and the `channel` events createMessage fires when it lazily
+// creates one of those channels. A `Last-Event-ID` header holding the
+// CreatedAt of the last message the caller saw is backfilled internally the
+// same way `listMessages?after=...` would.
+func streamMessages(w ResponseWriter, r Request) error {
+	ctx := appengine.NewContext(r.Req())
+
+	user, ok := r.Values()["user"].(*auth.User)
+	if !ok {
+		http.Error(w, "unauthorized", 401)
+		return nil
+	}
+
+	gameID, err := datastore.DecodeKey(r.Vars()["game_id"])
+	if err != nil {
+		return err
+	}
+
+	memberID, err := MemberID(ctx, gameID, user.Id)
+	if err != nil {
+		return err
+	}
+
+	var nation dip.Nation
+
+	game := &Game{}
+	member := &Member{}
+	err = datastore.GetMulti(ctx, []*datastore.Key{gameID, memberID}, []interface{}{game, member})
+	if err == nil {
+		nation = member.Nation
+	} else if merr, ok := err.(appengine.MultiError); ok {
+		if merr[0] != nil {
+			return merr[0]
+		}
+	} else {
+		return err
+	}
+
+	visible := func(event StreamEvent) bool {
+		switch event.Type {
+		case StreamEventMessage:
+			return event.Message != nil && (event.Message.ChannelMembers.Includes(nation) || isPublic(game.Variant, event.Message.ChannelMembers))
+		case StreamEventChannel:
+			return event.Channel != nil && (event.Channel.Members.Includes(nation) || isPublic(game.Variant, event.Channel.Members))
+		default:
+			return false
+		}
+	}
+
+	var backfill []StreamEvent
+	if lastEventID := r.Req().Header.Get("Last-Event-ID"); lastEventID != "" {
+		if since, err := time.Parse(time.RFC3339Nano, lastEventID); err == nil {
+			channelIDs, err := callerChannelIDs(ctx, gameID, game, nation)
+			if err != nil {
+				return err
+			}
+			missed := Messages{}
+			for _, channelID := range channelIDs {
+				msgs, err := messageStore.List(ctx, channelID, ListOpts{After: &since, Limit: maxMessagesLimit})
+				if err != nil {
+					return err
+				}
+				missed = append(missed, msgs...)
+			}
+			sort.Sort(sort.Reverse(messagesByCreatedAt(missed)))
+			backfill = messagesToEvents(missed)
+		}
+	}
+
+	return streamEvents(w, r, gameID, StreamMessagesRoute, visible, backfill)
+}
+
+// streamChannels implements `GET /Game/{game_id}/Channels/Stream`: `channel`
+// events for channels the caller belongs to (plus the public channel), and
+// `read-marker` events for the caller's own marker.
+//
+// Unlike streamMessages, this endpoint has no Last-Event-ID backfill, so
+// delivery of `channel` and `read-marker` events is at-most-once and only as
+// reliable as gameBroker itself: on multi-instance appengine, an event
+// published on the instance that handled createMessage/setReadMarker is
+// never delivered to a subscriber connected to a different instance. Callers
+// that need a reliable channel/read-marker picture should treat this stream
+// as a hint to re-fetch, not as the source of truth (poll listChannels or
+// the read marker instead of trusting every event arrives).
+func streamChannels(w ResponseWriter, r Request) error {
+	ctx := appengine.NewContext(r.Req())
+
+	user, ok := r.Values()["user"].(*auth.User)
+	if !ok {
+		http.Error(w, "unauthorized", 401)
+		return nil
+	}
+
+	gameID, err := datastore.DecodeKey(r.Vars()["game_id"])
+	if err != nil {
+		return err
+	}
+
+	memberID, err := MemberID(ctx, gameID, user.Id)
+	if err != nil {
+		return err
+	}
+
+	var nation dip.Nation
+
+	game := &Game{}
+	member := &Member{}
+	err = datastore.GetMulti(ctx, []*datastore.Key{gameID, memberID}, []interface{}{game, member})
+	if err == nil {
+		nation = member.Nation
+	} else if merr, ok := err.(appengine.MultiError); ok {
+		if merr[0] != nil {
+			return merr[0]
+		}
+	} else {
+		return err
+	}
+
+	visible := func(event StreamEvent) bool {
+		switch event.Type {
+		case StreamEventChannel:
+			return event.Channel != nil && (event.Channel.Members.Includes(nation) || isPublic(game.Variant, event.Channel.Members))
+		case StreamEventReadMarker:
+			return event.ReadMarker != nil && event.ReadMarker.UserID == user.Id
+		default:
+			return false
+		}
+	}
+
+	return streamEvents(w, r, gameID, StreamChannelsRoute, visible, nil)
+}